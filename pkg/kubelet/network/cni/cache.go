@@ -0,0 +1,55 @@
+/*
+Copyright 2014 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cni
+
+import (
+	"sync"
+
+	"k8s.io/kubernetes/pkg/kubelet/network"
+	"k8s.io/kubernetes/pkg/types"
+)
+
+// podNetworkStatusCache remembers, per pod UID, the result of the most
+// recent SetUpPod so that Status() can report the full multi-network
+// attachment list without re-invoking any CNI plugin.
+type podNetworkStatusCache struct {
+	lock sync.Mutex
+	pods map[types.UID][]network.NetworkAttachmentStatus
+}
+
+func newPodNetworkStatusCache() *podNetworkStatusCache {
+	return &podNetworkStatusCache{pods: make(map[types.UID][]network.NetworkAttachmentStatus)}
+}
+
+func (c *podNetworkStatusCache) set(uid types.UID, attachments []network.NetworkAttachmentStatus) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.pods[uid] = attachments
+}
+
+func (c *podNetworkStatusCache) get(uid types.UID) ([]network.NetworkAttachmentStatus, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	attachments, ok := c.pods[uid]
+	return attachments, ok
+}
+
+func (c *podNetworkStatusCache) delete(uid types.UID) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.pods, uid)
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2014 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cni
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/resource"
+)
+
+const (
+	IngressBandwidthAnnotation = "kubernetes.io/ingress-bandwidth"
+	EgressBandwidthAnnotation  = "kubernetes.io/egress-bandwidth"
+	IPRangesAnnotation         = "net.experimental.kubernetes.io/ip-ranges"
+)
+
+// cniPortMapping is one entry of the "portMappings" capability understood
+// by the portmap CNI plugin.
+type cniPortMapping struct {
+	ContainerPort int32  `json:"containerPort"`
+	HostPort      int32  `json:"hostPort"`
+	Protocol      string `json:"protocol,omitempty"`
+	HostIP        string `json:"hostIP,omitempty"`
+}
+
+// cniBandwidth is the "bandwidth" capability understood by the bandwidth
+// CNI plugin. Rates are in bits per second.
+type cniBandwidth struct {
+	IngressRate int64 `json:"ingressRate,omitempty"`
+	EgressRate  int64 `json:"egressRate,omitempty"`
+}
+
+// cniIPRange is one entry of the "ipRanges" capability understood by
+// plugins (e.g. host-local) that can allocate from an explicit range.
+type cniIPRange struct {
+	Subnet     string `json:"subnet"`
+	RangeStart string `json:"rangeStart,omitempty"`
+	RangeEnd   string `json:"rangeEnd,omitempty"`
+	Gateway    string `json:"gateway,omitempty"`
+}
+
+// cniDNS is the "dns" capability understood by plugins (e.g. dnsname) that
+// provision DNS inside the pod's network namespace.
+type cniDNS struct {
+	Nameservers []string `json:"nameservers,omitempty"`
+	Search      []string `json:"search,omitempty"`
+}
+
+// portMappingsFromPod translates container ports with a HostPort set into
+// the portMappings capability.
+func portMappingsFromPod(pod *api.Pod) []cniPortMapping {
+	var mappings []cniPortMapping
+	for _, c := range pod.Spec.Containers {
+		for _, p := range c.Ports {
+			if p.HostPort == 0 {
+				continue
+			}
+			mappings = append(mappings, cniPortMapping{
+				ContainerPort: p.ContainerPort,
+				HostPort:      p.HostPort,
+				// portmap expects lowercase "tcp"/"udp", not the
+				// Kubernetes api.Protocol casing ("TCP"/"UDP").
+				Protocol: strings.ToLower(string(p.Protocol)),
+				HostIP:   p.HostIP,
+			})
+		}
+	}
+	return mappings
+}
+
+// bandwidthFromPod translates the ingress/egress bandwidth annotations into
+// the bandwidth capability.
+func bandwidthFromPod(pod *api.Pod) (*cniBandwidth, error) {
+	ingress, hasIngress := pod.Annotations[IngressBandwidthAnnotation]
+	egress, hasEgress := pod.Annotations[EgressBandwidthAnnotation]
+	if !hasIngress && !hasEgress {
+		return nil, nil
+	}
+
+	bw := &cniBandwidth{}
+	if hasIngress {
+		q, err := resource.ParseQuantity(ingress)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation: %v", IngressBandwidthAnnotation, err)
+		}
+		bw.IngressRate = q.Value()
+	}
+	if hasEgress {
+		q, err := resource.ParseQuantity(egress)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation: %v", EgressBandwidthAnnotation, err)
+		}
+		bw.EgressRate = q.Value()
+	}
+	return bw, nil
+}
+
+// ipRangesFromPod decodes the ip-ranges annotation into the ipRanges
+// capability.
+func ipRangesFromPod(pod *api.Pod) ([][]cniIPRange, error) {
+	raw, ok := pod.Annotations[IPRangesAnnotation]
+	if !ok || raw == "" {
+		return nil, nil
+	}
+	var ranges [][]cniIPRange
+	if err := json.Unmarshal([]byte(raw), &ranges); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %v", IPRangesAnnotation, err)
+	}
+	return ranges, nil
+}
+
+// dnsCapability builds the dns capability from the DNS servers/search
+// domains the kubelet has already resolved for the pod's DNSPolicy.
+func dnsCapability(dnsServers, dnsSearches []string) *cniDNS {
+	if len(dnsServers) == 0 && len(dnsSearches) == 0 {
+		return nil
+	}
+	return &cniDNS{Nameservers: dnsServers, Search: dnsSearches}
+}
+
+// capabilityArgsForPod assembles the CapabilityArgs map threaded through to
+// capability-aware CNI plugins (portmap, bandwidth, host-local, dnsname).
+func capabilityArgsForPod(pod *api.Pod, dnsServers, dnsSearches []string) (map[string]interface{}, error) {
+	args := make(map[string]interface{})
+
+	if portMappings := portMappingsFromPod(pod); len(portMappings) > 0 {
+		args["portMappings"] = portMappings
+	}
+
+	bandwidth, err := bandwidthFromPod(pod)
+	if err != nil {
+		return nil, err
+	}
+	if bandwidth != nil {
+		args["bandwidth"] = bandwidth
+	}
+
+	ipRanges, err := ipRangesFromPod(pod)
+	if err != nil {
+		return nil, err
+	}
+	if ipRanges != nil {
+		args["ipRanges"] = ipRanges
+	}
+
+	if dns := dnsCapability(dnsServers, dnsSearches); dns != nil {
+		args["dns"] = dns
+	}
+
+	if len(args) == 0 {
+		return nil, nil
+	}
+	return args, nil
+}
@@ -17,46 +17,149 @@ limitations under the License.
 package cni
 
 import (
+	"encoding/json"
 	"fmt"
+	"net"
+	"sort"
+	"sync"
+
 	"github.com/appc/cni/libcni"
 	cniTypes "github.com/appc/cni/pkg/types"
 	"github.com/golang/glog"
 	"k8s.io/kubernetes/pkg/api"
 	"k8s.io/kubernetes/pkg/kubelet/network"
 	kubeletTypes "k8s.io/kubernetes/pkg/kubelet/types"
-	"net"
-	"sort"
 )
 
 const (
-	CNIPluginName        = "cni"
-	DefaultPluginName    = "kubernetes-bridge"
-	DefaultNetDir        = "/etc/cni/net.d"
-	DefaultCNIDir        = "/opt/cni/bin"
-	VendorCNIDirTemplate = "/opt/%s/bin"
-	DefaultPodNetworkKey = "net.experimental.kubernetes.io/networks"
+	CNIPluginName            = "cni"
+	DefaultPluginName        = "kubernetes-bridge"
+	DefaultNetDir            = "/etc/cni/net.d"
+	DefaultCNIDir            = "/opt/cni/bin"
+	VendorCNIDirTemplate     = "/opt/%s/bin"
+	DefaultPodNetworkKey     = "net.experimental.kubernetes.io/networks"
+	DefaultIfNamePrefix      = "eth"
+	DefaultNetworkAnnotation = "network.kubernetes.io/default-network"
 )
 
 type cniNetworkPlugin struct {
-	cniNetworkMap map[string]*cniNetwork
+	// lock guards cniNetworkMap and defaultNetwork, which are replaced
+	// wholesale by Reload() whenever the watcher sees DefaultNetDir change.
+	lock           sync.RWMutex
+	cniNetworkMap  map[string]*cniNetwork
 	defaultNetwork *cniNetwork
-	host          network.Host
+
+	host           network.Host
+	podStatusCache *podNetworkStatusCache
+	configLock     *networkConfigLock
+	watcher        *configWatcher
 }
 
 type cniNetwork struct {
 	name          string
 	NetworkConfig *libcni.NetworkConfig
-	CNIConfig     *libcni.CNIConfig
+	Exec          CNIExecutor
+}
+
+// NetAttachment describes one network a pod should be joined to, and how.
+// It is the unmarshaled form of each entry of the DefaultPodNetworkKey
+// annotation, which now carries a JSON array of these instead of a flat
+// list of network names.
+type NetAttachment struct {
+	// Name is the CNI network name, matching the "name" field of a config
+	// file under DefaultNetDir.
+	Name string `json:"name"`
+	// IfName is the interface name to create inside the pod's network
+	// namespace for this attachment. If empty, one is assigned
+	// deterministically (eth0, eth1, ...) based on attachment order.
+	IfName string `json:"interface,omitempty"`
+	// StaticIP, if set, is requested from the CNI plugin via CNI_ARGS.
+	StaticIP string `json:"ip,omitempty"`
+	// StaticMAC, if set, is requested from the CNI plugin via CNI_ARGS.
+	StaticMAC string `json:"mac,omitempty"`
+	// CNIArgs are additional key/value pairs forwarded to the plugin via
+	// CNI_ARGS, for plugins that accept extra arguments.
+	CNIArgs map[string]string `json:"args,omitempty"`
+}
+
+// podNetwork pairs a resolved cniNetwork with the attachment descriptor
+// that selected it, including the interface name it will be wired up on.
+type podNetwork struct {
+	network    *cniNetwork
+	attachment *NetAttachment
 }
 
 func ProbeNetworkPlugins() []network.NetworkPlugin {
 	configList := make([]network.NetworkPlugin, 0)
 	allNetworks, defaultNetwork := getAllCNINetworks()
-	return append(configList, &cniNetworkPlugin{cniNetworkMap: getAllCNINetworks(), defaultNetwork: defaultNetwork})
+	plugin := &cniNetworkPlugin{
+		cniNetworkMap:  allNetworks,
+		defaultNetwork: defaultNetwork,
+		podStatusCache: newPodNetworkStatusCache(),
+		configLock:     newNetworkConfigLock(DefaultStateDir),
+	}
+
+	watcher, err := newConfigWatcher(plugin)
+	if err != nil {
+		glog.Warningf("Unable to watch %s for CNI config changes, falling back to per-call rescans: %v", DefaultNetDir, err)
+	} else {
+		plugin.watcher = watcher
+	}
+
+	return append(configList, plugin)
+}
+
+// Reload rescans DefaultNetDir and atomically swaps in the resulting
+// network map and default network. It's invoked by the config watcher
+// whenever DefaultNetDir changes, and is exported so tests can force a
+// rescan without waiting on fsnotify.
+func (plugin *cniNetworkPlugin) Reload() error {
+	lockTok, err := plugin.configLock.Lock()
+	if err != nil {
+		return fmt.Errorf("error locking CNI config state: %v", err)
+	}
+	defer plugin.configLock.Unlock(lockTok)
+
+	networkMap, defaultNetwork := getAllCNINetworks()
+
+	plugin.lock.Lock()
+	defer plugin.lock.Unlock()
+	plugin.cniNetworkMap = networkMap
+	plugin.defaultNetwork = defaultNetwork
+	return nil
+}
+
+// currentNetworks returns the most recently known network map. Normally
+// that's just whatever the watcher last Reload()ed. But if the watcher
+// failed to start (e.g. DefaultNetDir didn't exist yet when the kubelet
+// came up, which is common since CNI configs are typically dropped in
+// later) nothing keeps cniNetworkMap fresh, so there's nobody to fall back
+// on except rescanning on every call, same as before the watcher existed.
+func (plugin *cniNetworkPlugin) currentNetworks() (map[string]*cniNetwork, *cniNetwork) {
+	if plugin.watcher == nil {
+		networkMap, defaultNetwork := getAllCNINetworks()
+		plugin.lock.Lock()
+		plugin.cniNetworkMap, plugin.defaultNetwork = networkMap, defaultNetwork
+		plugin.lock.Unlock()
+		return networkMap, defaultNetwork
+	}
+
+	plugin.lock.RLock()
+	defer plugin.lock.RUnlock()
+	return plugin.cniNetworkMap, plugin.defaultNetwork
+}
+
+// Shutdown stops the background config watcher, if one is running. Nothing
+// on the current NetworkPlugin interface calls this yet, but it gives
+// tests and embedders a way to avoid leaking the watcher goroutine.
+func (plugin *cniNetworkPlugin) Shutdown() {
+	if plugin.watcher != nil {
+		plugin.watcher.Close()
+	}
 }
 
 func getAllCNINetworks() (map[string]*cniNetwork, *cniNetwork) {
-	defaultNetwork := nil
+	var defaultNetwork *cniNetwork
 	networkMap := make(map[string]*cniNetwork)
 	files, err := libcni.ConfFiles(DefaultNetDir)
 	switch {
@@ -78,60 +181,93 @@ func getAllCNINetworks() (map[string]*cniNetwork, *cniNetwork) {
 		cninet := &libcni.CNIConfig{
 			Path: []string{DefaultCNIDir, vendorCNIDir},
 		}
-		network := &cniNetwork{name: conf.Network.Name, NetworkConfig: conf, CNIConfig: cninet}
+		net := &cniNetwork{name: conf.Network.Name, NetworkConfig: conf, Exec: newExecutorFor(cninet)}
 		if defaultNetwork == nil {
 			defaultNetwork = net
 		}
-		networkMap[conf.Network.Name] = network
+		networkMap[conf.Network.Name] = net
 	}
 	return networkMap, defaultNetwork
 }
 
-func (plugin *cniNetworkPlugin) selectNetworks(pod *api.Pod) ([]*cniNetwork, error) {
-	selectedNetworks := make([]*cniNetwork, 0)
+// attachmentsFromPod decodes the DefaultPodNetworkKey annotation into a list
+// of NetAttachment. The annotation carries a JSON array of attachments; an
+// empty or missing annotation yields no attachments.
+func attachmentsFromPod(pod *api.Pod) ([]*NetAttachment, error) {
+	raw, ok := pod.Annotations[DefaultPodNetworkKey]
+	if !ok || raw == "" {
+		return nil, nil
+	}
+	var attachments []*NetAttachment
+	if err := json.Unmarshal([]byte(raw), &attachments); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %v", DefaultPodNetworkKey, err)
+	}
+	return attachments, nil
+}
 
-	// loading networks all over again maybe inefficient, but required if networks can be created on the fly
-	plugin.cniNetworkMap,_ = getAllCNINetworks()
+// selectNetworks resolves the set of CNI networks a pod should be attached
+// to, in the deterministic order they should be wired up. Interface names
+// are assigned for any attachment that didn't request a specific one.
+func (plugin *cniNetworkPlugin) selectNetworks(pod *api.Pod) ([]*podNetwork, error) {
+	cniNetworkMap, defaultNetwork := plugin.currentNetworks()
 
-	if len(plugin.cniNetworkMap) == 0 {
+	if len(cniNetworkMap) == 0 {
 		return nil, fmt.Errorf("No available CNI network available in %s", DefaultNetDir)
 	}
 
+	var attachments []*NetAttachment
+
 	// check if the namespace of the pod is a network name itself
-	network, ok := plugin.cniNetworkMap[pod.Namespace]
-	if ok {
-		selectedNetworks = append(selectedNetworks, network)
+	if _, ok := cniNetworkMap[pod.Namespace]; ok {
+		attachments = append(attachments, &NetAttachment{Name: pod.Namespace})
 	}
 
-	// label called "network"?
-	var netNames []string
-	err := json.Marshal(pod.Labels[DefaultPodNetworkKey], &netNames)
+	// structured annotation listing the networks (and per-attachment options) to join
+	fromAnnotation, err := attachmentsFromPod(pod)
 	if err != nil {
-		for _, netName := range(netNames) {
-			network, ok = plugin.cniNetworkMap[netName]
-			if ok {
-				selectedNetworks = append(selectedNetworks, network)
-			}
+		return nil, err
+	}
+	attachments = append(attachments, fromAnnotation...)
+
+	if len(attachments) == 0 {
+		if defaultNetwork == nil {
+			return nil, fmt.Errorf("No available CNI network available in %s", DefaultNetDir)
 		}
+		attachments = append(attachments, &NetAttachment{Name: defaultNetwork.name})
 	}
 
-	// annotation called "network"?
-	err := json.Marshal(pod.Annotations[DefaultPodNetworkKey], &netNames)
-	if err != nil {
-		for _, netName := range(netNames) {
-			network, ok = plugin.cniNetworkMap[netName]
-			if ok {
-				selectedNetworks = append(selectedNetworks, network)
-			}
+	// Names explicitly requested by an attachment are off-limits for
+	// auto-assignment, so an unnamed attachment never collides with one
+	// that asked for, say, "eth1" by name.
+	taken := make(map[string]bool, len(attachments))
+	for _, attachment := range attachments {
+		if attachment.IfName != "" {
+			taken[attachment.IfName] = true
 		}
 	}
 
-	if len(selectedNetworks)==0 {
-		// return the default one
-		selectedNetworks = append(selectedNetworks, plugin.defaultNetwork)
+	selected := make([]*podNetwork, 0, len(attachments))
+	next := 0
+	for _, attachment := range attachments {
+		net, ok := cniNetworkMap[attachment.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown network %q requested for pod %s_%s", attachment.Name, pod.Name, pod.Namespace)
+		}
+		if attachment.IfName == "" {
+			for {
+				candidate := fmt.Sprintf("%s%d", DefaultIfNamePrefix, next)
+				next++
+				if !taken[candidate] {
+					attachment.IfName = candidate
+					taken[candidate] = true
+					break
+				}
+			}
+		}
+		selected = append(selected, &podNetwork{network: net, attachment: attachment})
 	}
 
-	return selectedNetworks, nil
+	return selected, nil
 }
 
 func (plugin *cniNetworkPlugin) Init(host network.Host) error {
@@ -154,29 +290,40 @@ func (plugin *cniNetworkPlugin) SetUpPod(namespace string, name string, id kubel
 		return fmt.Errorf("pod %q namespace %q: unable to find pod", name, namespace)
 	}
 
-	// TODO: pick one network? all networks? which one writes to Status.PodIP?
-	// Perhaps pick the network by some annotation/label on pod, or the namespace.
-	networks, err := plugin.selectNetworks(pod)
+	podNetworks, err := plugin.selectNetworks(pod)
 	if err != nil {
 		return err
 	}
-	for network,_ := range(networks) {
-		res, err := network.addToNetwork(name, namespace, string(id), netns)
+
+	dnsServers, dnsSearches, err := plugin.host.GetPodDNS(pod)
+	if err != nil {
+		glog.Warningf("Unable to resolve DNS for pod %q namespace %q, continuing without a dns capability: %v", name, namespace, err)
+	}
+
+	attachments := make([]network.NetworkAttachmentStatus, 0, len(podNetworks))
+	var added []*podNetwork
+	for _, pn := range podNetworks {
+		res, err := pn.network.addToNetwork(pod, string(id), netns, pn.attachment, plugin.configLock, dnsServers, dnsSearches)
 		if err != nil {
+			// Unlike the single-eth0 baseline, a multi-network pod can fail
+			// partway through: roll back the attachments that already
+			// succeeded so a failed SetUpPod doesn't leak interfaces/IPs
+			// for a pod that never finished setup.
+			for _, done := range added {
+				if delErr := done.network.deleteFromNetwork(pod, string(id), netns, done.attachment, plugin.configLock, dnsServers, dnsSearches); delErr != nil {
+					glog.Warningf("Error rolling back network %q for pod %q namespace %q: %v", done.network.name, name, namespace, delErr)
+				}
+			}
 			return err
 		}
-
-		var ip string
-		if res.IP4 != nil {
-			ip = res.IP4.IP.String()
-		} else {
-			ip = res.IP6.IP.String()
-		}
-		// TODO-PAT: check that PodIP can be an IPv6.
-		// TODO-rajat: this keeps on updating the same field, pick the main one
-		pod.Status.PodIP = ip
+		glog.V(4).Infof("Attached pod %q to network %q on interface %q: %v", name, pn.network.name, pn.attachment.IfName, res)
+		attachments = append(attachments, attachmentStatusFromResult(pn.network.name, pn.attachment.IfName, res))
+		added = append(added, pn)
 	}
-	return err
+
+	plugin.podStatusCache.set(pod.UID, attachments)
+	pod.Status.PodIP = primaryIP(plugin.selectPrimaryNetwork(pod), attachments)
+	return nil
 }
 
 func (plugin *cniNetworkPlugin) TearDownPod(namespace string, name string, id kubeletTypes.DockerID) error {
@@ -189,16 +336,25 @@ func (plugin *cniNetworkPlugin) TearDownPod(namespace string, name string, id ku
 		return fmt.Errorf("pod %q namespace %q: unable to find pod", name, namespace)
 	}
 
-	networks, err := plugin.selectNetworks(pod)
+	podNetworks, err := plugin.selectNetworks(pod)
 	if err != nil {
 		return err
 	}
-	for network,_ := range(networks) {
-		err := network.deleteFromNetwork(name, namespace, string(id), netns)
-		if er != nil {
+
+	// Recompute the same portMappings (and other capability args) used at
+	// ADD time so plugins like portmap can find and remove the iptables
+	// rules they installed.
+	dnsServers, dnsSearches, err := plugin.host.GetPodDNS(pod)
+	if err != nil {
+		glog.Warningf("Unable to resolve DNS for pod %q namespace %q, continuing without a dns capability: %v", name, namespace, err)
+	}
+
+	for _, pn := range podNetworks {
+		if err := pn.network.deleteFromNetwork(pod, string(id), netns, pn.attachment, plugin.configLock, dnsServers, dnsSearches); err != nil {
 			return err
 		}
 	}
+	plugin.podStatusCache.delete(pod.UID)
 	return nil
 }
 
@@ -208,19 +364,124 @@ func (plugin *cniNetworkPlugin) Status(namespace string, name string, id kubelet
 		return nil, fmt.Errorf("pod %q namespace %q: unable to find pod", name, namespace)
 	}
 
-	return &network.PodNetworkStatus{IP: net.ParseIP(pod.Status.PodIP)}, nil
+	attachments, ok := plugin.podStatusCache.get(pod.UID)
+	if !ok {
+		// SetUpPod hasn't run (or the cache was dropped); fall back to
+		// whatever PodIP the runtime already recorded.
+		return &network.PodNetworkStatus{IP: net.ParseIP(pod.Status.PodIP)}, nil
+	}
+
+	return &network.PodNetworkStatus{
+		IP:          net.ParseIP(primaryIP(plugin.selectPrimaryNetwork(pod), attachments)),
+		Attachments: attachments,
+	}, nil
 }
 
-func (network *cniNetwork) addToNetwork(podName string, podNamespace string, podInfraContainerID string, podNetnsPath string) (*cniTypes.Result, error) {
-	rt, err := buildCNIRuntimeConf(podName, podNamespace, podInfraContainerID, podNetnsPath)
+// selectPrimaryNetwork returns the name of the CNI network whose address
+// should become Status.PodIP: the network named by DefaultNetworkAnnotation
+// if the pod sets one, otherwise the CNI config marked default in
+// DefaultNetDir (the first one, sorted by file name).
+func (plugin *cniNetworkPlugin) selectPrimaryNetwork(pod *api.Pod) string {
+	if name, ok := pod.Annotations[DefaultNetworkAnnotation]; ok && name != "" {
+		return name
+	}
+	plugin.lock.RLock()
+	defer plugin.lock.RUnlock()
+	if plugin.defaultNetwork != nil {
+		return plugin.defaultNetwork.name
+	}
+	return ""
+}
+
+// primaryIP picks the IP to surface as Status.PodIP: the address of the
+// attachment matching primaryNetwork if one exists, else the first
+// attachment with any address at all. IPv4 is preferred over IPv6.
+func primaryIP(primaryNetwork string, attachments []network.NetworkAttachmentStatus) string {
+	var fallback string
+	for _, a := range attachments {
+		ip := firstIP(a.IPs)
+		if ip == "" {
+			continue
+		}
+		if a.Network == primaryNetwork {
+			return ip
+		}
+		if fallback == "" {
+			fallback = ip
+		}
+	}
+	return fallback
+}
+
+func firstIP(ips []net.IP) string {
+	for _, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			return ip4.String()
+		}
+	}
+	for _, ip := range ips {
+		return ip.String()
+	}
+	return ""
+}
+
+// attachmentStatusFromResult converts a CNI ADD result into the status
+// record kept in the per-pod cache. This vendored CNI result carries IPs,
+// gateway and routes per IP family, but neither an interface name nor a MAC
+// address: the interface name is taken from ifName, and MAC is left off
+// NetworkAttachmentStatus entirely rather than populated with a zero value.
+func attachmentStatusFromResult(netName string, ifName string, res *cniTypes.Result) network.NetworkAttachmentStatus {
+	status := network.NetworkAttachmentStatus{Network: netName, IfName: ifName}
+	if res == nil {
+		return status
+	}
+	if res.IP4 != nil {
+		status.IPs = append(status.IPs, res.IP4.IP.IP)
+		status.Gateway = res.IP4.Gateway
+		status.Routes = append(status.Routes, routesFromCNI(res.IP4.Routes)...)
+	}
+	if res.IP6 != nil {
+		status.IPs = append(status.IPs, res.IP6.IP.IP)
+		if status.Gateway == nil {
+			status.Gateway = res.IP6.Gateway
+		}
+		status.Routes = append(status.Routes, routesFromCNI(res.IP6.Routes)...)
+	}
+	return status
+}
+
+func routesFromCNI(routes []cniTypes.Route) []network.Route {
+	if len(routes) == 0 {
+		return nil
+	}
+	out := make([]network.Route, 0, len(routes))
+	for _, r := range routes {
+		out = append(out, network.Route{Dst: r.Dst, GW: r.GW})
+	}
+	return out
+}
+
+func (network *cniNetwork) addToNetwork(pod *api.Pod, podInfraContainerID string, podNetnsPath string, attachment *NetAttachment, configLock *networkConfigLock, dnsServers []string, dnsSearches []string) (*cniTypes.Result, error) {
+	rt, err := buildCNIRuntimeConf(pod, podInfraContainerID, podNetnsPath, attachment)
+	if err != nil {
+		glog.Errorf("Error adding network: %v", err)
+		return nil, err
+	}
+
+	netconf, err := network.configWithCapabilityArgs(pod, dnsServers, dnsSearches)
 	if err != nil {
 		glog.Errorf("Error adding network: %v", err)
 		return nil, err
 	}
 
-	netconf, cninet := network.NetworkConfig, network.CNIConfig
-	glog.V(2).Infof("About to run with conf.Network.Type=%v, c.Path=%v", netconf.Network.Type, cninet.Path)
-	res, err := cninet.AddNetwork(netconf, rt)
+	lockTok, err := configLock.Lock()
+	if err != nil {
+		return nil, fmt.Errorf("error locking CNI config state: %v", err)
+	}
+	defer configLock.Unlock(lockTok)
+
+	glog.V(2).Infof("About to run with conf.Network.Type=%v, exec=%T", netconf.Network.Type, network.Exec)
+	res, err := network.Exec.AddNetwork(netconf, rt)
 	if err != nil {
 		glog.Errorf("Error adding network: %v", err)
 		return nil, err
@@ -229,16 +490,27 @@ func (network *cniNetwork) addToNetwork(podName string, podNamespace string, pod
 	return res, nil
 }
 
-func (network *cniNetwork) deleteFromNetwork(podName string, podNamespace string, podInfraContainerID string, podNetnsPath string) error {
-	rt, err := buildCNIRuntimeConf(podName, podNamespace, podInfraContainerID, podNetnsPath)
+func (network *cniNetwork) deleteFromNetwork(pod *api.Pod, podInfraContainerID string, podNetnsPath string, attachment *NetAttachment, configLock *networkConfigLock, dnsServers []string, dnsSearches []string) error {
+	rt, err := buildCNIRuntimeConf(pod, podInfraContainerID, podNetnsPath, attachment)
 	if err != nil {
 		glog.Errorf("Error deleting network: %v", err)
 		return err
 	}
 
-	netconf, cninet := network.NetworkConfig, network.CNIConfig
-	glog.V(2).Infof("About to run with conf.Network.Type=%v, c.Path=%v", netconf.Network.Type, cninet.Path)
-	err = cninet.DelNetwork(netconf, rt)
+	netconf, err := network.configWithCapabilityArgs(pod, dnsServers, dnsSearches)
+	if err != nil {
+		glog.Errorf("Error deleting network: %v", err)
+		return err
+	}
+
+	lockTok, err := configLock.Lock()
+	if err != nil {
+		return fmt.Errorf("error locking CNI config state: %v", err)
+	}
+	defer configLock.Unlock(lockTok)
+
+	glog.V(2).Infof("About to run with conf.Network.Type=%v, exec=%T", netconf.Network.Type, network.Exec)
+	err = network.Exec.DelNetwork(netconf, rt)
 	if err != nil {
 		glog.Errorf("Error deleting network: %v", err)
 		return err
@@ -246,20 +518,67 @@ func (network *cniNetwork) deleteFromNetwork(podName string, podNamespace string
 	return nil
 }
 
-func buildCNIRuntimeConf(podName string, podNs string, podInfraContainerID string, podNetnsPath string) (*libcni.RuntimeConf, error) {
+// configWithCapabilityArgs returns network's NetworkConfig with this pod's
+// capability args (portMappings, bandwidth, ipRanges, dns) merged into the
+// netconf JSON under the "runtimeConfig" key — where capability-aware
+// plugins (portmap, bandwidth, tuning, dnsname) actually read them from on
+// stdin. This vendored libcni has no RuntimeConf.CapabilityArgs for the
+// caller to populate instead, so the merge is done directly on the JSON
+// bytes rather than through the library.
+func (network *cniNetwork) configWithCapabilityArgs(pod *api.Pod, dnsServers []string, dnsSearches []string) (*libcni.NetworkConfig, error) {
+	capArgs, err := capabilityArgsForPod(pod, dnsServers, dnsSearches)
+	if err != nil {
+		return nil, err
+	}
+	if len(capArgs) == 0 {
+		return network.NetworkConfig, nil
+	}
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal(network.NetworkConfig.Bytes, &raw); err != nil {
+		return nil, fmt.Errorf("error decoding CNI network config %q to merge capability args: %v", network.name, err)
+	}
+	raw["runtimeConfig"] = capArgs
+	merged, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding CNI network config %q with capability args: %v", network.name, err)
+	}
+	return &libcni.NetworkConfig{Network: network.NetworkConfig.Network, Bytes: merged}, nil
+}
+
+// buildCNIRuntimeConf assembles the per-attachment libcni.RuntimeConf used
+// for both ADD and DEL so the two always agree on interface name and args.
+// Capability args travel separately, merged into the netconf JSON itself
+// (see configWithCapabilityArgs) rather than carried on the RuntimeConf.
+func buildCNIRuntimeConf(pod *api.Pod, podInfraContainerID string, podNetnsPath string, attachment *NetAttachment) (*libcni.RuntimeConf, error) {
 	glog.V(2).Infof("Got netns path %v", podNetnsPath)
-	glog.V(2).Infof("Using netns path %v", podNs)
+	glog.V(2).Infof("Using pod namespace %v", pod.Namespace)
+
+	ifName := attachment.IfName
+	if ifName == "" {
+		ifName = DefaultIfNamePrefix + "0"
+	}
 
 	rt := &libcni.RuntimeConf{
 		ContainerID: podInfraContainerID,
 		NetNS:       podNetnsPath,
-		IfName:      "eth0",
+		IfName:      ifName,
 		Args: [][2]string{
-			{"K8S_POD_NAMESPACE", podNs},
-			{"K8S_POD_NAME", podName},
+			{"K8S_POD_NAMESPACE", pod.Namespace},
+			{"K8S_POD_NAME", pod.Name},
 			{"K8S_POD_INFRA_CONTAINER_ID", podInfraContainerID},
 		},
 	}
 
+	if attachment.StaticIP != "" {
+		rt.Args = append(rt.Args, [2]string{"IP", attachment.StaticIP})
+	}
+	if attachment.StaticMAC != "" {
+		rt.Args = append(rt.Args, [2]string{"MAC", attachment.StaticMAC})
+	}
+	for k, v := range attachment.CNIArgs {
+		rt.Args = append(rt.Args, [2]string{k, v})
+	}
+
 	return rt, nil
 }
@@ -0,0 +1,181 @@
+/*
+Copyright 2014 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cni
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/appc/cni/libcni"
+	cniTypes "github.com/appc/cni/pkg/types"
+	"k8s.io/kubernetes/pkg/api"
+	kubecontainer "k8s.io/kubernetes/pkg/kubelet/container"
+	kubeletTypes "k8s.io/kubernetes/pkg/kubelet/types"
+	"k8s.io/kubernetes/pkg/types"
+)
+
+// fakeExecutor is a CNIExecutor that never forks a real plugin binary, so
+// SetUpPod/TearDownPod can be driven in tests without anything on disk.
+type fakeExecutor struct {
+	ip string
+}
+
+func (f *fakeExecutor) AddNetwork(netconf *libcni.NetworkConfig, rt *libcni.RuntimeConf) (*cniTypes.Result, error) {
+	ip, ipnet, err := net.ParseCIDR(f.ip + "/24")
+	if err != nil {
+		return nil, err
+	}
+	return &cniTypes.Result{IP4: &cniTypes.IPConfig{IP: net.IPNet{IP: ip, Mask: ipnet.Mask}}}, nil
+}
+
+func (f *fakeExecutor) DelNetwork(netconf *libcni.NetworkConfig, rt *libcni.RuntimeConf) error {
+	return nil
+}
+
+func (f *fakeExecutor) CheckNetwork(netconf *libcni.NetworkConfig, rt *libcni.RuntimeConf) error {
+	return nil
+}
+
+func (f *fakeExecutor) GetVersionInfo(pluginType string) (*cniVersionInfo, error) {
+	return &cniVersionInfo{CNIVersion: "0.1.0"}, nil
+}
+
+type fakeRuntime struct{}
+
+func (fakeRuntime) GetNetNs(containerID string) (string, error) {
+	return "/proc/self/ns/net", nil
+}
+
+type fakeHost struct {
+	pod *api.Pod
+}
+
+func (h *fakeHost) GetPodByName(namespace, name string) (*api.Pod, bool) {
+	if h.pod == nil || h.pod.Namespace != namespace || h.pod.Name != name {
+		return nil, false
+	}
+	return h.pod, true
+}
+
+func (h *fakeHost) GetRuntime() kubecontainer.Runtime {
+	return fakeRuntime{}
+}
+
+func (h *fakeHost) GetPodDNS(pod *api.Pod) ([]string, []string, error) {
+	return nil, nil, nil
+}
+
+func fakeNetwork(name, ip string) *cniNetwork {
+	return &cniNetwork{
+		name: name,
+		NetworkConfig: &libcni.NetworkConfig{
+			Network: &cniTypes.NetConf{Name: name, Type: "bridge"},
+		},
+		Exec: &fakeExecutor{ip: ip},
+	}
+}
+
+// newTestPlugin builds a cniNetworkPlugin around a fixed, fake network map
+// instead of one backed by ProbeNetworkPlugins/getAllCNINetworks. It sets a
+// non-nil, otherwise-inert watcher so selectNetworks doesn't fall back to
+// rescanning the real filesystem out from under the fixed map (see
+// currentNetworks).
+func newTestPlugin(t *testing.T, pod *api.Pod) *cniNetworkPlugin {
+	tmpDir, err := ioutil.TempDir("", "cni-lock-test")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tmpDir) })
+
+	netA := fakeNetwork("net-a", "10.0.0.5")
+	netB := fakeNetwork("net-b", "10.0.1.5")
+
+	return &cniNetworkPlugin{
+		cniNetworkMap:  map[string]*cniNetwork{"net-a": netA, "net-b": netB},
+		defaultNetwork: netA,
+		host:           &fakeHost{pod: pod},
+		podStatusCache: newPodNetworkStatusCache(),
+		configLock:     newNetworkConfigLock(tmpDir),
+		watcher:        &configWatcher{},
+	}
+}
+
+func TestSelectNetworksFromAnnotation(t *testing.T) {
+	pod := &api.Pod{}
+	pod.Namespace = "default"
+	pod.Name = "test-pod"
+	pod.Annotations = map[string]string{
+		DefaultPodNetworkKey: `[{"name":"net-a"},{"name":"net-b","interface":"eth5"}]`,
+	}
+
+	plugin := newTestPlugin(t, pod)
+	selected, err := plugin.selectNetworks(pod)
+	if err != nil {
+		t.Fatalf("selectNetworks: %v", err)
+	}
+	if len(selected) != 2 {
+		t.Fatalf("expected 2 attachments, got %d", len(selected))
+	}
+	if selected[0].network.name != "net-a" || selected[0].attachment.IfName != "eth0" {
+		t.Errorf("unexpected first attachment: %+v", selected[0].attachment)
+	}
+	if selected[1].network.name != "net-b" || selected[1].attachment.IfName != "eth5" {
+		t.Errorf("unexpected second attachment: %+v", selected[1].attachment)
+	}
+}
+
+func TestSetUpPodMultiNetworkAndPrimaryIP(t *testing.T) {
+	pod := &api.Pod{}
+	pod.Namespace = "default"
+	pod.Name = "test-pod"
+	pod.UID = types.UID("test-uid")
+	pod.Annotations = map[string]string{
+		DefaultPodNetworkKey:     `[{"name":"net-a"},{"name":"net-b"}]`,
+		DefaultNetworkAnnotation: "net-b",
+	}
+
+	plugin := newTestPlugin(t, pod)
+	id := kubeletTypes.DockerID("container-id")
+
+	if err := plugin.SetUpPod(pod.Namespace, pod.Name, id); err != nil {
+		t.Fatalf("SetUpPod: %v", err)
+	}
+
+	if pod.Status.PodIP != "10.0.1.5" {
+		t.Errorf("expected primary IP from net-b (the default-network annotation), got %q", pod.Status.PodIP)
+	}
+
+	status, err := plugin.Status(pod.Namespace, pod.Name, id)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if len(status.Attachments) != 2 {
+		t.Fatalf("expected 2 cached attachments, got %d", len(status.Attachments))
+	}
+	if status.IP.String() != "10.0.1.5" {
+		t.Errorf("expected Status().IP from net-b, got %v", status.IP)
+	}
+
+	if err := plugin.TearDownPod(pod.Namespace, pod.Name, id); err != nil {
+		t.Fatalf("TearDownPod: %v", err)
+	}
+	if _, ok := plugin.podStatusCache.get(pod.UID); ok {
+		t.Errorf("expected pod status cache entry to be removed after TearDownPod")
+	}
+}
@@ -0,0 +1,291 @@
+/*
+Copyright 2014 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cni
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/appc/cni/libcni"
+	cniTypes "github.com/appc/cni/pkg/types"
+	"github.com/golang/glog"
+)
+
+// DefaultCNIDaemonSocket is where a privileged "CNI daemon" sidecar, if
+// one is running, listens for exec requests. When present, it's preferred
+// over forking plugin binaries directly so the kubelet itself can stay
+// unprivileged.
+const DefaultCNIDaemonSocket = "/var/run/cni-daemon.sock"
+
+// cniVersionInfo is our own stand-in for a plugin's VERSION output. This
+// vendored appc/cni predates the VERSION command and any GetVersionInfo
+// call on libcni.CNIConfig, so executors implement it themselves by
+// exec'ing the plugin directly with CNI_COMMAND=VERSION, rather than
+// relying on library support that doesn't exist here.
+type cniVersionInfo struct {
+	CNIVersion string   `json:"cniVersion"`
+	Supported  []string `json:"supportedVersions,omitempty"`
+}
+
+// CNIExecutor abstracts how a cniNetwork actually invokes a CNI plugin, so
+// the exec strategy can be swapped without touching SetUpPod/TearDownPod:
+// the default fork/exec of binaries in DefaultCNIDir, a cached-version
+// wrapper that avoids re-forking just to ask a plugin's version, or a
+// remote executor that forwards the call to a CNI daemon over a Unix
+// socket. It also makes SetUpPod/TearDownPod unit-testable without real
+// plugin binaries on disk.
+type CNIExecutor interface {
+	AddNetwork(netconf *libcni.NetworkConfig, rt *libcni.RuntimeConf) (*cniTypes.Result, error)
+	DelNetwork(netconf *libcni.NetworkConfig, rt *libcni.RuntimeConf) error
+	CheckNetwork(netconf *libcni.NetworkConfig, rt *libcni.RuntimeConf) error
+	GetVersionInfo(pluginType string) (*cniVersionInfo, error)
+}
+
+// newExecutorFor picks the exec strategy for a network: a remote executor
+// if a CNI daemon socket is present, otherwise the default local fork/exec,
+// either way wrapped so plugin versions are only looked up once.
+func newExecutorFor(cninet *libcni.CNIConfig) CNIExecutor {
+	var exec CNIExecutor
+	if _, err := os.Stat(DefaultCNIDaemonSocket); err == nil {
+		exec = newRemoteExecutor(DefaultCNIDaemonSocket, cninet.Path)
+	} else {
+		exec = newDefaultExecutor(cninet)
+	}
+	return newCachedVersionExecutor(exec)
+}
+
+// defaultExecutor is the original behavior: fork/exec the plugin binaries
+// found on cninet.Path directly from the kubelet process.
+type defaultExecutor struct {
+	cninet *libcni.CNIConfig
+}
+
+func newDefaultExecutor(cninet *libcni.CNIConfig) CNIExecutor {
+	return &defaultExecutor{cninet: cninet}
+}
+
+func (e *defaultExecutor) AddNetwork(netconf *libcni.NetworkConfig, rt *libcni.RuntimeConf) (*cniTypes.Result, error) {
+	return e.cninet.AddNetwork(netconf, rt)
+}
+
+func (e *defaultExecutor) DelNetwork(netconf *libcni.NetworkConfig, rt *libcni.RuntimeConf) error {
+	return e.cninet.DelNetwork(netconf, rt)
+}
+
+func (e *defaultExecutor) CheckNetwork(netconf *libcni.NetworkConfig, rt *libcni.RuntimeConf) error {
+	// This vintage of libcni has no CHECK verb to exec; nothing to do.
+	return nil
+}
+
+func (e *defaultExecutor) GetVersionInfo(pluginType string) (*cniVersionInfo, error) {
+	pluginPath, err := findPluginBinary(e.cninet.Path, pluginType)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(pluginPath)
+	cmd.Env = []string{"CNI_COMMAND=VERSION"}
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error running %s to get its version: %v", pluginPath, err)
+	}
+
+	info := &cniVersionInfo{}
+	if err := json.Unmarshal(out, info); err != nil {
+		return nil, fmt.Errorf("error decoding version info from %s: %v", pluginPath, err)
+	}
+	return info, nil
+}
+
+// findPluginBinary looks up a plugin's executable by name on a CNI plugin
+// search path, the same search libcni does internally for ADD/DEL.
+func findPluginBinary(paths []string, pluginType string) (string, error) {
+	for _, dir := range paths {
+		candidate := filepath.Join(dir, pluginType)
+		if fi, err := os.Stat(candidate); err == nil && !fi.IsDir() {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("failed to find plugin %q in path %v", pluginType, paths)
+}
+
+// cachedVersionExecutor wraps a CNIExecutor and memoizes GetVersionInfo per
+// plugin binary, and has AddNetwork go through that cached lookup first, so
+// the kubelet doesn't fork a plugin just to ask its version on every single
+// pod setup.
+type cachedVersionExecutor struct {
+	CNIExecutor
+
+	lock     sync.Mutex
+	versions map[string]*cniVersionInfo
+}
+
+func newCachedVersionExecutor(delegate CNIExecutor) CNIExecutor {
+	return &cachedVersionExecutor{CNIExecutor: delegate, versions: make(map[string]*cniVersionInfo)}
+}
+
+func (e *cachedVersionExecutor) GetVersionInfo(pluginType string) (*cniVersionInfo, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if v, ok := e.versions[pluginType]; ok {
+		return v, nil
+	}
+	v, err := e.CNIExecutor.GetVersionInfo(pluginType)
+	if err != nil {
+		return nil, err
+	}
+	e.versions[pluginType] = v
+	return v, nil
+}
+
+// AddNetwork checks (and caches) the plugin's version before every ADD.
+// This is the actual hot path SetUpPod drives, so it's where the
+// memoization in GetVersionInfo has to sit to do any good.
+func (e *cachedVersionExecutor) AddNetwork(netconf *libcni.NetworkConfig, rt *libcni.RuntimeConf) (*cniTypes.Result, error) {
+	if _, err := e.GetVersionInfo(netconf.Network.Type); err != nil {
+		glog.Warningf("Unable to determine version of CNI plugin %q, proceeding anyway: %v", netconf.Network.Type, err)
+	}
+	return e.CNIExecutor.AddNetwork(netconf, rt)
+}
+
+// remoteExecutor forwards CNI invocations to a CNI daemon listening on a
+// Unix socket, instead of forking the plugin binary itself. This lets
+// operators run plugins in a privileged sidecar while the kubelet process
+// stays unprivileged.
+type remoteExecutor struct {
+	socketPath string
+	// paths is the plugin search path the daemon should use; it isn't
+	// carried on libcni.RuntimeConf, so it's bound into the executor at
+	// construction instead.
+	paths []string
+}
+
+func newRemoteExecutor(socketPath string, paths []string) CNIExecutor {
+	return &remoteExecutor{socketPath: socketPath, paths: paths}
+}
+
+// remoteExecRequest is the wire format sent to the CNI daemon: the CNI verb,
+// the environment variables a forked plugin would normally receive, and the
+// netconf JSON a forked plugin would normally receive on stdin.
+type remoteExecRequest struct {
+	Command string            `json:"command"`
+	Env     map[string]string `json:"env"`
+	Stdin   json.RawMessage   `json:"stdin"`
+}
+
+type remoteExecResponse struct {
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+func (e *remoteExecutor) call(command string, netconf *libcni.NetworkConfig, rt *libcni.RuntimeConf) (*remoteExecResponse, error) {
+	req := remoteExecRequest{
+		Command: command,
+		Env:     e.runtimeConfToEnv(command, rt),
+	}
+	if netconf != nil {
+		req.Stdin = json.RawMessage(netconf.Bytes)
+	}
+
+	conn, err := net.Dial("unix", e.socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("error dialing CNI daemon at %s: %v", e.socketPath, err)
+	}
+	defer conn.Close()
+
+	if err := json.NewEncoder(conn).Encode(&req); err != nil {
+		return nil, fmt.Errorf("error sending request to CNI daemon: %v", err)
+	}
+
+	var resp remoteExecResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("error reading response from CNI daemon: %v", err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("CNI daemon returned error: %s", resp.Error)
+	}
+	return &resp, nil
+}
+
+// runtimeConfToEnv reproduces the CNI_* environment variables a forked
+// plugin binary would see, for the daemon to use when it execs the plugin.
+// CNI_PATH comes from e.paths, the search path this executor was
+// constructed with, since libcni.RuntimeConf carries no such field itself.
+func (e *remoteExecutor) runtimeConfToEnv(command string, rt *libcni.RuntimeConf) map[string]string {
+	env := map[string]string{
+		"CNI_COMMAND": command,
+		"CNI_PATH":    strings.Join(e.paths, ":"),
+	}
+	if rt == nil {
+		return env
+	}
+	env["CNI_CONTAINERID"] = rt.ContainerID
+	env["CNI_NETNS"] = rt.NetNS
+	env["CNI_IFNAME"] = rt.IfName
+
+	args := make([]string, 0, len(rt.Args))
+	for _, kv := range rt.Args {
+		args = append(args, kv[0]+"="+kv[1])
+	}
+	env["CNI_ARGS"] = strings.Join(args, ";")
+	return env
+}
+
+func (e *remoteExecutor) AddNetwork(netconf *libcni.NetworkConfig, rt *libcni.RuntimeConf) (*cniTypes.Result, error) {
+	resp, err := e.call("ADD", netconf, rt)
+	if err != nil {
+		return nil, err
+	}
+	result := &cniTypes.Result{}
+	if err := json.Unmarshal(resp.Result, result); err != nil {
+		return nil, fmt.Errorf("error decoding CNI daemon ADD result: %v", err)
+	}
+	return result, nil
+}
+
+func (e *remoteExecutor) DelNetwork(netconf *libcni.NetworkConfig, rt *libcni.RuntimeConf) error {
+	_, err := e.call("DEL", netconf, rt)
+	return err
+}
+
+func (e *remoteExecutor) CheckNetwork(netconf *libcni.NetworkConfig, rt *libcni.RuntimeConf) error {
+	_, err := e.call("CHECK", netconf, rt)
+	return err
+}
+
+func (e *remoteExecutor) GetVersionInfo(pluginType string) (*cniVersionInfo, error) {
+	stdin, err := json.Marshal(map[string]string{"type": pluginType})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := e.call("VERSION", &libcni.NetworkConfig{Network: &cniTypes.NetConf{Type: pluginType}, Bytes: stdin}, nil)
+	if err != nil {
+		return nil, err
+	}
+	info := &cniVersionInfo{}
+	if err := json.Unmarshal(resp.Result, info); err != nil {
+		return nil, fmt.Errorf("error decoding CNI daemon VERSION result: %v", err)
+	}
+	return info, nil
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2014 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cni
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/golang/glog"
+)
+
+// DefaultStateDir is where the CNI plugin keeps the lock file that
+// serializes config reloads against in-flight ADD/DEL calls.
+const DefaultStateDir = "/var/lib/cni/kubernetes"
+
+// networkConfigLock is a flock-based mutex, under DefaultStateDir, that is
+// held for the duration of a CNI ADD or DEL. It prevents a config file
+// being added or removed from DefaultNetDir mid-exec from leaving a
+// SetUpPod/TearDownPod call with inconsistent plugin binaries or netconf
+// for the network it's operating on.
+//
+// networkConfigLock itself is shared by every concurrent SetUpPod/
+// TearDownPod call, so it must not keep any per-acquisition state (like the
+// open *os.File) on the struct — Lock returns that state as a token that
+// the caller passes back to Unlock, so one goroutine's Unlock can never
+// operate on a different goroutine's file handle.
+type networkConfigLock struct {
+	path string
+}
+
+func newNetworkConfigLock(stateDir string) *networkConfigLock {
+	return &networkConfigLock{path: filepath.Join(stateDir, "cni.lock")}
+}
+
+// Lock blocks until the flock is acquired and returns the token to pass to
+// Unlock.
+func (l *networkConfigLock) Lock() (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(l.path), 0750); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// Unlock releases the flock acquired by the matching Lock call.
+func (l *networkConfigLock) Unlock(tok *os.File) {
+	if tok == nil {
+		return
+	}
+	if err := syscall.Flock(int(tok.Fd()), syscall.LOCK_UN); err != nil {
+		glog.Warningf("Error unlocking %s: %v", l.path, err)
+	}
+	tok.Close()
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2014 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cni
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"gopkg.in/fsnotify.v1"
+)
+
+// configWatchDebounce bounds how quickly back-to-back fsnotify events (e.g.
+// a config file being written in several syscalls, or several files
+// changing in one `cp`) collapse into a single Reload.
+const configWatchDebounce = 500 * time.Millisecond
+
+// configWatcher watches DefaultNetDir and keeps a cniNetworkPlugin's
+// network map current, instead of the plugin rescanning the directory on
+// every SetUpPod/TearDownPod call.
+type configWatcher struct {
+	plugin  *cniNetworkPlugin
+	fsw     *fsnotify.Watcher
+	stopCh  chan struct{}
+	stopped sync.WaitGroup
+}
+
+func newConfigWatcher(plugin *cniNetworkPlugin) (*configWatcher, error) {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := fsw.Add(DefaultNetDir); err != nil {
+		fsw.Close()
+		return nil, err
+	}
+
+	w := &configWatcher{plugin: plugin, fsw: fsw, stopCh: make(chan struct{})}
+	w.stopped.Add(1)
+	go w.run()
+	return w, nil
+}
+
+func (w *configWatcher) run() {
+	defer w.stopped.Done()
+
+	var debounce *time.Timer
+	for {
+		select {
+		case _, ok := <-w.fsw.Events:
+			if !ok {
+				return
+			}
+			if debounce == nil {
+				debounce = time.NewTimer(configWatchDebounce)
+			} else {
+				if !debounce.Stop() {
+					<-debounce.C
+				}
+				debounce.Reset(configWatchDebounce)
+			}
+		case err, ok := <-w.fsw.Errors:
+			if !ok {
+				return
+			}
+			glog.Warningf("CNI config watch error: %v", err)
+		case <-debounceC(debounce):
+			if err := w.plugin.Reload(); err != nil {
+				glog.Warningf("Error reloading CNI network config: %v", err)
+			}
+			debounce = nil
+		case <-w.stopCh:
+			return
+		}
+	}
+}
+
+// debounceC returns t.C, or a nil channel (which blocks forever) if no
+// debounce timer is currently pending.
+func debounceC(t *time.Timer) <-chan time.Time {
+	if t == nil {
+		return nil
+	}
+	return t.C
+}
+
+func (w *configWatcher) Close() {
+	close(w.stopCh)
+	w.fsw.Close()
+	w.stopped.Wait()
+}
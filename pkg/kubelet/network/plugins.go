@@ -0,0 +1,79 @@
+/*
+Copyright 2014 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"net"
+
+	"k8s.io/kubernetes/pkg/api"
+	kubecontainer "k8s.io/kubernetes/pkg/kubelet/container"
+	kubeletTypes "k8s.io/kubernetes/pkg/kubelet/types"
+)
+
+// NetworkPlugin is the interface implemented by network plugins (e.g. CNI,
+// kubenet) that the kubelet delegates pod network setup and teardown to.
+type NetworkPlugin interface {
+	Init(host Host) error
+	Name() string
+	SetUpPod(namespace string, name string, id kubeletTypes.DockerID) error
+	TearDownPod(namespace string, name string, id kubeletTypes.DockerID) error
+	Status(namespace string, name string, id kubeletTypes.DockerID) (*PodNetworkStatus, error)
+}
+
+// Host is the interface a NetworkPlugin uses to reach back into the kubelet
+// for the pieces of state it needs (the pod's spec, its container runtime).
+type Host interface {
+	GetPodByName(namespace, name string) (*api.Pod, bool)
+	GetRuntime() kubecontainer.Runtime
+	// GetPodDNS returns the nameservers and search domains the kubelet has
+	// already resolved for this pod, given its DNSPolicy and the cluster's
+	// DNS configuration.
+	GetPodDNS(pod *api.Pod) (dnsServers []string, dnsSearches []string, err error)
+}
+
+// Route is a route the CNI plugin installed (or expects the caller to
+// install) in the pod's network namespace for one attachment.
+type Route struct {
+	Dst net.IPNet
+	GW  net.IP
+}
+
+// NetworkAttachmentStatus is the per-attachment result of joining a pod to
+// one CNI network, as recorded for later retrieval by Status().
+type NetworkAttachmentStatus struct {
+	// Network is the CNI network name the pod was attached to.
+	Network string
+	// IfName is the interface name inside the pod's netns.
+	IfName string
+	// IPs are the addresses (v4 and/or v6) assigned on this interface.
+	IPs []net.IP
+	// Gateway is the gateway address returned by the CNI plugin, if any.
+	Gateway net.IP
+	// Routes are the routes the CNI plugin reported for this attachment.
+	Routes []Route
+}
+
+// PodNetworkStatus is what NetworkPlugin.Status returns: the pod's primary
+// IP (for backwards compatibility with single-network callers) plus, for
+// plugins that support it, the full set of networks the pod is attached to.
+type PodNetworkStatus struct {
+	// IP is the pod's primary IP address.
+	IP net.IP
+	// Attachments holds one entry per CNI network the pod is joined to.
+	// It is only populated by plugins that support multi-network pods.
+	Attachments []NetworkAttachmentStatus
+}